@@ -0,0 +1,87 @@
+/*-
+ * Copyright 2016 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+
+	"github.com/square/sharkey/pkg/server/config"
+	"github.com/square/sharkey/pkg/server/storage"
+)
+
+// runRevoke implements `sharkey revoke --serial N --reason compromise`,
+// recording a revocation directly against the configured backing store so
+// it's picked up by the next KRL request. A revocation given only by
+// --principal is recorded and marks that principal's issued certs revoked,
+// but the KRL lists serials only, so it does not appear there.
+func runRevoke(args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	configFile := fs.String("config", "/etc/sharkey/sharkeyd.yaml", "path to server config")
+	serial := fs.Uint64("serial", 0, "serial number of the certificate to revoke")
+	principal := fs.String("principal", "", "principal the certificate was issued to, if serial is unknown")
+	certType := fs.String("type", "host", "certificate type to revoke (host|user)")
+	reason := fs.String("reason", "", "reason for revocation, recorded for audit purposes")
+	ttl := fs.Duration("ttl", 365*24*time.Hour, "how long the revocation should remain in the KRL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *serial == 0 && *principal == "" {
+		return fmt.Errorf("revoke: one of --serial or --principal is required")
+	}
+	if *reason == "" {
+		return fmt.Errorf("revoke: --reason is required")
+	}
+
+	var typ uint32
+	switch *certType {
+	case "host":
+		typ = 2 // ssh.HostCert
+	case "user":
+		typ = 1 // ssh.UserCert
+	default:
+		return fmt.Errorf("revoke: unknown certificate type %q", *certType)
+	}
+
+	raw, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		return fmt.Errorf("revoke: unable to read config: %s", err.Error())
+	}
+	var conf config.Config
+	if err := yaml.Unmarshal(raw, &conf); err != nil {
+		return fmt.Errorf("revoke: unable to parse config: %s", err.Error())
+	}
+
+	db, err := storage.NewStorage(conf.Database)
+	if err != nil {
+		return fmt.Errorf("revoke: unable to connect to database: %s", err.Error())
+	}
+	defer db.Close()
+
+	expiresAt := time.Now().Add(*ttl)
+	if err := db.RecordRevocation(*serial, *principal, typ, *reason, expiresAt); err != nil {
+		return fmt.Errorf("revoke: %s", err.Error())
+	}
+
+	fmt.Printf("revoked serial %d (expires from KRL at %s)\n", *serial, expiresAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,89 @@
+/*-
+ * Copyright 2016 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config is the top level configuration for the sharkey server.
+type Config struct {
+	ListenAddr   string              `yaml:"listen_addr"`
+	CertDuration string              `yaml:"cert_duration"`
+	StripSuffix  string              `yaml:"strip_suffix"`
+	Aliases      map[string][]string `yaml:"aliases"`
+	Database     Database            `yaml:"database"`
+}
+
+// Database holds the configuration needed to connect to the backing store.
+type Database struct {
+	// Type selects the storage backend: "mysql" (default), "sqlite",
+	// "postgres" or "memory".
+	Type     string `yaml:"type"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Address  string `yaml:"address"`
+	Schema   string `yaml:"schema"`
+	// Path is the path to the database file, for the sqlite backend.
+	Path string `yaml:"path"`
+	TLS  *TLS   `yaml:"tls"`
+}
+
+// TLS holds the configuration needed to connect to the backing store over
+// an encrypted connection.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CAFile is the path to a PEM-encoded CA bundle used to verify the
+	// server's certificate. If empty, the system root CAs are used.
+	CAFile string `yaml:"ca_file"`
+	// ServerName overrides the hostname used to verify the server's
+	// certificate (and for SNI), for when it doesn't match the address
+	// the database is dialed on.
+	ServerName string `yaml:"server_name"`
+	// SkipVerify disables verification of the server's certificate. It
+	// should only be set for local testing.
+	SkipVerify bool `yaml:"skip_verify"`
+}
+
+// BuildTLS constructs a *tls.Config from the given TLS configuration.
+func BuildTLS(cfg TLS) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.SkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("config: no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,284 @@
+/*-
+ * Copyright 2016 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/square/sharkey/pkg/server/config"
+)
+
+type hostkeyRecord struct {
+	pubkey string
+	serial uint64
+}
+
+type revocationRecord struct {
+	serial    uint64
+	principal string
+	reason    string
+	expiresAt time.Time
+}
+
+// MemoryStorage is an in-memory Storage implementation backed by maps and
+// a sync.RWMutex, for unit tests and demo/dev deployments where losing
+// state on restart is fine.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	hostkeys       map[string]hostkeyRecord // keyed by hostname+"\x00"+certType
+	revocations    map[uint32][]revocationRecord
+	githubMappings map[string]string
+	issuedCerts    []IssuedCertRecord
+	nextSerial     uint64
+}
+
+var _ Storage = &MemoryStorage{}
+
+// NewMemory constructs an empty MemoryStorage. cfg is accepted (and
+// ignored) to match the other storage constructors' signatures.
+func NewMemory(cfg config.Database) (*MemoryStorage, error) {
+	return &MemoryStorage{
+		hostkeys:       make(map[string]hostkeyRecord),
+		revocations:    make(map[uint32][]revocationRecord),
+		githubMappings: make(map[string]string),
+	}, nil
+}
+
+func hostkeyKey(hostname string, certType uint32) string {
+	return hostname + "\x00" + strconv.FormatUint(uint64(certType), 10)
+}
+
+func (m *MemoryStorage) RecordIssuance(certType uint32, principal string, pubkey ssh.PublicKey) (uint64, error) {
+	if _, err := certTypeName(certType); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextSerial++
+	serial := m.nextSerial
+
+	m.hostkeys[hostkeyKey(principal, certType)] = hostkeyRecord{
+		pubkey: string(ssh.MarshalAuthorizedKey(pubkey)),
+		serial: serial,
+	}
+
+	return serial, nil
+}
+
+func (m *MemoryStorage) QueryHostkeys() (ResultIterator, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pairs [][2]string
+	for key, record := range m.hostkeys {
+		hostname := strings.SplitN(key, "\x00", 2)[0]
+		if !strings.HasSuffix(key, "\x00"+strconv.FormatUint(uint64(ssh.HostCert), 10)) {
+			continue
+		}
+		pairs = append(pairs, [2]string{hostname, record.pubkey})
+	}
+
+	return &sliceResultIterator{pairs: pairs}, nil
+}
+
+func (m *MemoryStorage) RecordRevocation(serial uint64, principal string, certType uint32, reason string, expiresAt time.Time) error {
+	if _, err := certTypeName(certType); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revocations[certType] = append(m.revocations[certType], revocationRecord{
+		serial:    serial,
+		principal: principal,
+		reason:    reason,
+		expiresAt: expiresAt,
+	})
+
+	// Flip the matching issued_certs row(s) so QueryIssued reflects
+	// revocation status without a separate join.
+	for i, cert := range m.issuedCerts {
+		if cert.CertType != certType {
+			continue
+		}
+		if serial != 0 {
+			if cert.Serial == serial {
+				m.issuedCerts[i].Revoked = true
+			}
+			continue
+		}
+		if cert.Principal == principal {
+			m.issuedCerts[i].Revoked = true
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) QueryRevoked(certType uint32) (ResultIterator, error) {
+	if _, err := certTypeName(certType); err != nil {
+		return &sliceResultIterator{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pairs [][2]string
+	now := time.Now()
+	for _, rev := range m.revocations[certType] {
+		if rev.expiresAt.Before(now) {
+			continue
+		}
+		serial := ""
+		if rev.serial != 0 {
+			serial = strconv.FormatUint(rev.serial, 10)
+		}
+		pairs = append(pairs, [2]string{serial, rev.principal})
+	}
+
+	return &sliceResultIterator{pairs: pairs}, nil
+}
+
+func (m *MemoryStorage) RecordGitHubMapping(mapping map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.githubMappings = make(map[string]string, len(mapping))
+	for ssoIdentity, githubUser := range mapping {
+		m.githubMappings[ssoIdentity] = githubUser
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) QueryGitHubMapping(ssoIdentity string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	githubUser, ok := m.githubMappings[ssoIdentity]
+	if !ok {
+		return "", fmt.Errorf("storage: no github mapping for %q", ssoIdentity)
+	}
+
+	return githubUser, nil
+}
+
+func (m *MemoryStorage) RecordIssuedCert(cert IssuedCertRecord) error {
+	if _, err := certTypeName(cert.CertType); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.issuedCerts = append(m.issuedCerts, cert)
+	return nil
+}
+
+func (m *MemoryStorage) QueryIssued(filter IssuedCertFilter) (ResultIterator, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches []IssuedCertRecord
+	for _, cert := range m.issuedCerts {
+		if filter.Serial != nil && cert.Serial != *filter.Serial {
+			continue
+		}
+		if filter.PrincipalLike != "" && !strings.Contains(cert.Principal, filter.PrincipalLike) {
+			continue
+		}
+		if filter.CertType != 0 && cert.CertType != filter.CertType {
+			continue
+		}
+		if !filter.Since.IsZero() && cert.IssuedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !cert.IssuedAt.Before(filter.Until) {
+			continue
+		}
+		if filter.Revoked != nil && cert.Revoked != *filter.Revoked {
+			continue
+		}
+		matches = append(matches, cert)
+	}
+
+	// Newest first, like the SQL backends' ORDER BY issued_at DESC.
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].IssuedAt.After(matches[j].IssuedAt)
+	})
+
+	var pairs [][2]string
+	for _, cert := range matches {
+		data, err := json.Marshal(cert)
+		if err != nil {
+			return &sliceResultIterator{}, err
+		}
+		pairs = append(pairs, [2]string{strconv.FormatUint(cert.Serial, 10), string(data)})
+	}
+
+	return &sliceResultIterator{pairs: pairs}, nil
+}
+
+// Migrate is a no-op: there's no schema to bring up to date in memory.
+func (m *MemoryStorage) Migrate() error {
+	return nil
+}
+
+// Close is a no-op: there's nothing to release.
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+// sliceResultIterator is a ResultIterator that walks a snapshot slice
+// taken under lock, so callers can keep iterating after releasing it.
+type sliceResultIterator struct {
+	pairs [][2]string
+	pos   int
+}
+
+func (s *sliceResultIterator) Next() bool {
+	if s.pos >= len(s.pairs) {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+func (s *sliceResultIterator) Get() (string, string) {
+	pair := s.pairs[s.pos-1]
+	return pair[0], pair[1]
+}
+
+func (s *sliceResultIterator) Err() error {
+	return nil
+}
+
+func (s *sliceResultIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,198 @@
+/*-
+ * Copyright 2016 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/square/sharkey/pkg/server/config"
+)
+
+func testPubkey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating test key: %s", err.Error())
+	}
+	signer, err := ssh.NewSignerFromSigner(priv)
+	if err != nil {
+		t.Fatalf("building signer: %s", err.Error())
+	}
+	return signer.PublicKey()
+}
+
+func TestMemoryStorageRecordIssuance(t *testing.T) {
+	m, err := NewMemory(config.Database{})
+	if err != nil {
+		t.Fatalf("NewMemory: %s", err.Error())
+	}
+
+	pubkey := testPubkey(t)
+	serial, err := m.RecordIssuance(ssh.HostCert, "host.example.com", pubkey)
+	if err != nil {
+		t.Fatalf("RecordIssuance: %s", err.Error())
+	}
+	if serial == 0 {
+		t.Fatalf("RecordIssuance returned serial 0, want non-zero")
+	}
+
+	iter, err := m.QueryHostkeys()
+	if err != nil {
+		t.Fatalf("QueryHostkeys: %s", err.Error())
+	}
+	defer iter.Close()
+
+	var hostnames []string
+	for iter.Next() {
+		hostname, _ := iter.Get()
+		hostnames = append(hostnames, hostname)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("iterating hostkeys: %s", err.Error())
+	}
+	if len(hostnames) != 1 || hostnames[0] != "host.example.com" {
+		t.Fatalf("QueryHostkeys = %v, want [host.example.com]", hostnames)
+	}
+}
+
+func TestMemoryStorageRecordIssuanceRejectsUnknownCertType(t *testing.T) {
+	m, err := NewMemory(config.Database{})
+	if err != nil {
+		t.Fatalf("NewMemory: %s", err.Error())
+	}
+
+	if _, err := m.RecordIssuance(0, "host.example.com", testPubkey(t)); err == nil {
+		t.Fatalf("RecordIssuance with unknown cert type succeeded, want error")
+	}
+}
+
+func TestMemoryStorageRevocationAppearsInQueryRevoked(t *testing.T) {
+	m, err := NewMemory(config.Database{})
+	if err != nil {
+		t.Fatalf("NewMemory: %s", err.Error())
+	}
+
+	err = m.RecordRevocation(42, "host.example.com", ssh.HostCert, "key compromised", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RecordRevocation: %s", err.Error())
+	}
+
+	iter, err := m.QueryRevoked(ssh.HostCert)
+	if err != nil {
+		t.Fatalf("QueryRevoked: %s", err.Error())
+	}
+	defer iter.Close()
+
+	if !iter.Next() {
+		t.Fatalf("QueryRevoked returned no rows, want the revocation just recorded")
+	}
+	serial, principal := iter.Get()
+	if serial != "42" || principal != "host.example.com" {
+		t.Fatalf("QueryRevoked = (%q, %q), want (\"42\", \"host.example.com\")", serial, principal)
+	}
+	if iter.Next() {
+		t.Fatalf("QueryRevoked returned more than one row")
+	}
+}
+
+func TestMemoryStorageExpiredRevocationIsExcluded(t *testing.T) {
+	m, err := NewMemory(config.Database{})
+	if err != nil {
+		t.Fatalf("NewMemory: %s", err.Error())
+	}
+
+	err = m.RecordRevocation(42, "host.example.com", ssh.HostCert, "key compromised", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("RecordRevocation: %s", err.Error())
+	}
+
+	iter, err := m.QueryRevoked(ssh.HostCert)
+	if err != nil {
+		t.Fatalf("QueryRevoked: %s", err.Error())
+	}
+	defer iter.Close()
+
+	if iter.Next() {
+		t.Fatalf("QueryRevoked returned an expired revocation")
+	}
+}
+
+func TestMemoryStorageRecordRevocationSyncsIssuedCerts(t *testing.T) {
+	m, err := NewMemory(config.Database{})
+	if err != nil {
+		t.Fatalf("NewMemory: %s", err.Error())
+	}
+
+	err = m.RecordIssuedCert(IssuedCertRecord{
+		Serial:    7,
+		Principal: "host.example.com",
+		CertType:  ssh.HostCert,
+		IssuedAt:  time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("RecordIssuedCert: %s", err.Error())
+	}
+
+	err = m.RecordRevocation(7, "host.example.com", ssh.HostCert, "key compromised", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RecordRevocation: %s", err.Error())
+	}
+
+	revoked := true
+	iter, err := m.QueryIssued(IssuedCertFilter{Revoked: &revoked})
+	if err != nil {
+		t.Fatalf("QueryIssued: %s", err.Error())
+	}
+	defer iter.Close()
+
+	if !iter.Next() {
+		t.Fatalf("QueryIssued found no revoked certs, want the one just revoked")
+	}
+	serial, _ := iter.Get()
+	if serial != "7" {
+		t.Fatalf("QueryIssued returned serial %q, want \"7\"", serial)
+	}
+}
+
+func TestMemoryStorageGitHubMappingRoundTrip(t *testing.T) {
+	m, err := NewMemory(config.Database{})
+	if err != nil {
+		t.Fatalf("NewMemory: %s", err.Error())
+	}
+
+	if err := m.RecordGitHubMapping(map[string]string{"alice@example.com": "alice"}); err != nil {
+		t.Fatalf("RecordGitHubMapping: %s", err.Error())
+	}
+
+	githubUser, err := m.QueryGitHubMapping("alice@example.com")
+	if err != nil {
+		t.Fatalf("QueryGitHubMapping: %s", err.Error())
+	}
+	if githubUser != "alice" {
+		t.Fatalf("QueryGitHubMapping = %q, want \"alice\"", githubUser)
+	}
+
+	if _, err := m.QueryGitHubMapping("bob@example.com"); err == nil {
+		t.Fatalf("QueryGitHubMapping for unmapped identity succeeded, want error")
+	}
+}
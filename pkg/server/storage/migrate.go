@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed migrations/mysql/*.sql
+var mysqlMigrations embed.FS
+
+//go:embed migrations/sqlite3/*.sql
+var sqliteMigrations embed.FS
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+const migrationsTable = "schema_migrations"
+
+// migrationsFor returns the embedded migrations directory for d, so
+// operators don't need to ship SQL files alongside the sharkey binary.
+func migrationsFor(d dialect) (fs.FS, string, error) {
+	switch d {
+	case dialectMySQL:
+		return mysqlMigrations, "migrations/mysql", nil
+	case dialectSQLite:
+		return sqliteMigrations, "migrations/sqlite3", nil
+	case dialectPostgres:
+		return postgresMigrations, "migrations/postgres", nil
+	default:
+		return nil, "", fmt.Errorf("storage: no migrations registered for dialect %q", d)
+	}
+}
+
+// runMigrations applies every embedded migration for d that hasn't
+// already been recorded in the schema_migrations table, in filename
+// order, each inside its own transaction.
+func runMigrations(db *sql.DB, d dialect) error {
+	migrations, dir, err := migrationsFor(d)
+	if err != nil {
+		return err
+	}
+
+	createTable := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version VARCHAR(255) NOT NULL PRIMARY KEY)", migrationsTable)
+	if _, err := db.Exec(createTable); err != nil {
+		return fmt.Errorf("unable to create %s table: %s", migrationsTable, err.Error())
+	}
+
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return fmt.Errorf("unable to read migrations: %s", err.Error())
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".sql") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		applied, err := migrationApplied(db, d, name)
+		if err != nil {
+			return fmt.Errorf("unable to check migration status: %s", err.Error())
+		}
+		if applied {
+			continue
+		}
+
+		contents, err := fs.ReadFile(migrations, dir+"/"+name)
+		if err != nil {
+			return fmt.Errorf("unable to read migration %s: %s", name, err.Error())
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("unable to start migration transaction: %s", err.Error())
+		}
+		if _, err := tx.Exec(upSection(string(contents))); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to run migration %s: %s", name, err.Error())
+		}
+		insert := fmt.Sprintf("INSERT INTO %s (version) VALUES (%s)", migrationsTable, bindVar(d, 1))
+		if _, err := tx.Exec(insert, name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to record migration %s: %s", name, err.Error())
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("unable to commit migration %s: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func migrationApplied(db *sql.DB, d dialect, name string) (bool, error) {
+	stmt := fmt.Sprintf("SELECT version FROM %s WHERE version = %s", migrationsTable, bindVar(d, 1))
+	var version string
+	err := db.QueryRow(stmt, name).Scan(&version)
+	switch err {
+	case nil:
+		return true, nil
+	case sql.ErrNoRows:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// upSection extracts the statements between the "-- +goose Up" and
+// "-- +goose Down" markers, which is all of a migration file we execute;
+// the "Down" section only matters for manual rollback.
+func upSection(sql string) string {
+	const upMarker = "-- +goose Up"
+	const downMarker = "-- +goose Down"
+
+	start := strings.Index(sql, upMarker)
+	if start == -1 {
+		return sql
+	}
+	start += len(upMarker)
+
+	if end := strings.Index(sql[start:], downMarker); end != -1 {
+		return sql[start : start+end]
+	}
+	return sql[start:]
+}
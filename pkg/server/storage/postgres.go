@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+	"github.com/square/sharkey/pkg/server/config"
+)
+
+// PostgresStorage implements the storage interface, using Postgres for
+// storage.
+type PostgresStorage struct {
+	*sqlStorage
+}
+
+var _ Storage = &PostgresStorage{}
+
+func NewPostgres(cfg config.Database) (*PostgresStorage, error) {
+	url := fmt.Sprintf("postgres://%s:%s@%s/%s", cfg.Username, cfg.Password, cfg.Address, cfg.Schema)
+	if cfg.TLS == nil {
+		url += "?sslmode=disable"
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStorage{&sqlStorage{DB: db, dialect: dialectPostgres}}, nil
+}
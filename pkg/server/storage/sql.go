@@ -0,0 +1,448 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialect identifies the SQL flavor a sqlStorage is talking to, so the
+// handful of statements that aren't portable (mostly upserts and bind
+// variable syntax) can be chosen at query time.
+type dialect string
+
+const (
+	dialectMySQL    dialect = "mysql"
+	dialectSQLite   dialect = "sqlite3"
+	dialectPostgres dialect = "postgres"
+)
+
+const (
+	certHostCert = "host_cert"
+	certUserCert = "user_cert"
+)
+
+// sqlStorage implements the Storage interface against any database/sql
+// driver, using dialect to paper over the handful of statements that
+// differ between backends. MysqlStorage, SqliteStorage and PostgresStorage
+// are thin wrappers that build one of these with the right dialect and
+// connection setup.
+type sqlStorage struct {
+	*sql.DB
+	dialect dialect
+}
+
+var _ Storage = &sqlStorage{}
+
+// bindVar returns the placeholder for the n'th (1-indexed) bind variable
+// in a query, in the syntax the dialect's driver expects.
+func bindVar(d dialect, n int) string {
+	if d == dialectPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// upsertHostkeySQL returns a statement that inserts a (hostname, pubkey,
+// cert_type, serial) row, overwriting pubkey and serial if the
+// hostname/cert_type pair already exists - re-enrolling a host is issued
+// a fresh serial, same as a first-time enrollment.
+func upsertHostkeySQL(d dialect) string {
+	switch d {
+	case dialectSQLite:
+		return "INSERT OR REPLACE INTO hostkeys (hostname, pubkey, cert_type, serial) VALUES (?, ?, ?, ?)"
+	case dialectPostgres:
+		return "INSERT INTO hostkeys (hostname, pubkey, cert_type, serial) VALUES ($1, $2, $3, $4) " +
+			"ON CONFLICT (hostname, cert_type) DO UPDATE SET pubkey = excluded.pubkey, serial = excluded.serial"
+	default:
+		return "INSERT INTO hostkeys (hostname, pubkey, cert_type, serial) VALUES (?, ?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE pubkey = VALUES(pubkey), serial = VALUES(serial)"
+	}
+}
+
+// insertCertSerialSQL returns a statement that inserts a new row into
+// cert_serials, whose generated id becomes the serial for an issuance.
+func insertCertSerialSQL(d dialect) string {
+	if d == dialectPostgres {
+		return "INSERT INTO cert_serials (cert_type) VALUES ($1) RETURNING id"
+	}
+	return "INSERT INTO cert_serials (cert_type) VALUES (?)"
+}
+
+// allocateSerial reserves a new, unique serial number for a certificate of
+// the given type by inserting a row into cert_serials and reading back the
+// id it was assigned. It must run inside tx so the allocation is rolled
+// back along with the rest of the issuance if anything fails.
+func allocateSerial(tx *sql.Tx, d dialect, certType string) (uint64, error) {
+	if d == dialectPostgres {
+		var id uint64
+		err := tx.QueryRow(insertCertSerialSQL(d), certType).Scan(&id)
+		return id, err
+	}
+
+	result, err := tx.Exec(insertCertSerialSQL(d), certType)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return uint64(id), err
+}
+
+func (s *sqlStorage) RecordIssuance(certType uint32, principal string, pubkey ssh.PublicKey) (uint64, error) {
+	pkdata := ssh.MarshalAuthorizedKey(pubkey)
+
+	typ, err := certTypeName(certType)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error recording issuance: %s", err.Error())
+	}
+
+	// Allocate the serial from a dedicated counter rather than relying on
+	// the hostkeys row's own auto-increment id: that breaks on the
+	// ON DUPLICATE KEY / ON CONFLICT path taken when re-enrolling a host,
+	// since the driver reports a last-insert-id of 0 for the update branch.
+	serial, err := allocateSerial(tx, s.dialect, typ)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("error allocating serial: %s", err.Error())
+	}
+
+	if _, err := tx.Exec(upsertHostkeySQL(s.dialect), principal, pkdata, typ, serial); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("error recording issuance: %s", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error recording issuance: %s", err.Error())
+	}
+
+	return serial, nil
+}
+
+func (s *sqlStorage) QueryHostkeys() (ResultIterator, error) {
+	rows, err := s.DB.Query(
+		fmt.Sprintf("SELECT hostname, pubkey FROM hostkeys WHERE cert_type = %s", bindVar(s.dialect, 1)),
+		certHostCert)
+	if err != nil {
+		return &SqlResultIterator{}, err
+	}
+	return &SqlResultIterator{Rows: rows}, nil
+}
+
+func (s *sqlStorage) RecordRevocation(serial uint64, principal string, certType uint32, reason string, expiresAt time.Time) error {
+	typ, err := certTypeName(certType)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error recording revocation: %s", err.Error())
+	}
+
+	placeholders := make([]string, 6)
+	for i := range placeholders {
+		placeholders[i] = bindVar(s.dialect, i+1)
+	}
+	stmt := fmt.Sprintf(
+		"INSERT INTO revocations (serial, principal, cert_type, reason, revoked_at, expires_at) VALUES (%s)",
+		strings.Join(placeholders, ", "))
+
+	if _, err := tx.Exec(stmt, serial, principal, typ, reason, time.Now(), expiresAt); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error recording revocation: %s", err.Error())
+	}
+
+	// Flip the matching issued_certs row(s) so /certs reflects revocation
+	// status without a separate join.
+	if err := markIssuedCertsRevoked(tx, s.dialect, serial, principal, typ); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error recording revocation: %s", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error recording revocation: %s", err.Error())
+	}
+
+	return nil
+}
+
+// markIssuedCertsRevoked flips issued_certs.revoked for the audit row(s)
+// this revocation applies to: by serial if it's known, otherwise by
+// principal and cert type.
+func markIssuedCertsRevoked(tx *sql.Tx, d dialect, serial uint64, principal string, typ string) error {
+	if serial != 0 {
+		stmt := fmt.Sprintf("UPDATE issued_certs SET revoked = %s WHERE serial = %s",
+			bindVar(d, 1), bindVar(d, 2))
+		_, err := tx.Exec(stmt, true, serial)
+		return err
+	}
+
+	stmt := fmt.Sprintf("UPDATE issued_certs SET revoked = %s WHERE principal = %s AND cert_type = %s",
+		bindVar(d, 1), bindVar(d, 2), bindVar(d, 3))
+	_, err := tx.Exec(stmt, true, principal, typ)
+	return err
+}
+
+func (s *sqlStorage) QueryRevoked(certType uint32) (ResultIterator, error) {
+	typ, err := certTypeName(certType)
+	if err != nil {
+		return &SqlResultIterator{}, err
+	}
+
+	stmt := fmt.Sprintf(
+		"SELECT serial, principal FROM revocations WHERE cert_type = %s AND expires_at > %s",
+		bindVar(s.dialect, 1), bindVar(s.dialect, 2))
+	rows, err := s.DB.Query(stmt, typ, time.Now())
+	if err != nil {
+		return &SqlResultIterator{}, err
+	}
+	return &SqlResultIterator{Rows: rows}, nil
+}
+
+// githubMappingUpsertPrefix returns the portion of the upsert statement
+// before "VALUES", so RecordGitHubMapping can splice in one (?, ?) group
+// per mapping for a single batched statement.
+func githubMappingUpsertPrefix(d dialect) string {
+	switch d {
+	case dialectSQLite:
+		return "INSERT OR REPLACE INTO github_user_mappings (sso_identity, github_username)"
+	case dialectPostgres:
+		return "INSERT INTO github_user_mappings (sso_identity, github_username)"
+	default:
+		return "REPLACE INTO github_user_mappings (sso_identity, github_username)"
+	}
+}
+
+func (s *sqlStorage) RecordGitHubMapping(mapping map[string]string) error {
+	// Prepare for batch insert
+	insertEntries := make([]string, 0, len(mapping))
+	insertValues := make([]interface{}, 0, len(mapping)*2)
+	deleteEntries := make([]string, 0, len(mapping))
+	deleteValues := make([]interface{}, 0, len(mapping))
+	n := 1
+	for ssoIdentity, githubUser := range mapping {
+		// Create one set of values for each mapping
+		insertEntries = append(insertEntries, fmt.Sprintf("(%s, %s)", bindVar(s.dialect, n), bindVar(s.dialect, n+1)))
+		n += 2
+		// Append matching values for mapping
+		insertValues = append(insertValues, ssoIdentity)
+		insertValues = append(insertValues, githubUser)
+
+		deleteEntries = append(deleteEntries, bindVar(s.dialect, len(deleteEntries)+1))
+		deleteValues = append(deleteValues, ssoIdentity)
+	}
+
+	// Delete if not found in GitHub results
+	deleteStmt := fmt.Sprintf(
+		"DELETE FROM github_user_mappings WHERE sso_identity NOT IN (%s)",
+		strings.Join(deleteEntries, ","))
+	_, err := s.DB.Exec(deleteStmt, deleteValues...)
+	if err != nil {
+		return fmt.Errorf("error deleting mappings: %s", err.Error())
+	}
+
+	insertStmt := fmt.Sprintf("%s VALUES %s", githubMappingUpsertPrefix(s.dialect), strings.Join(insertEntries, ","))
+	if s.dialect == dialectPostgres {
+		insertStmt += " ON CONFLICT (sso_identity) DO UPDATE SET github_username = excluded.github_username"
+	}
+	// Execute with blown up values that match into the bind var groups inserted into the statement
+	if _, err := s.DB.Exec(insertStmt, insertValues...); err != nil {
+		return fmt.Errorf("error recording mapping: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) QueryGitHubMapping(ssoIdentity string) (string, error) {
+	stmt := fmt.Sprintf("SELECT github_username FROM github_user_mappings WHERE sso_identity = %s", bindVar(s.dialect, 1))
+	row := s.DB.QueryRow(stmt, ssoIdentity)
+	var githubUser string
+	if err := row.Scan(&githubUser); err != nil {
+		return "", err
+	}
+
+	return githubUser, nil
+}
+
+// RecordIssuedCert writes an audit row for a certificate that was just
+// signed. Callers that mint certificates should call this alongside
+// RecordIssuance once they have the extra metadata (fingerprint, key ID,
+// validity window, requester identity) that RecordIssuance itself isn't
+// given.
+func (s *sqlStorage) RecordIssuedCert(cert IssuedCertRecord) error {
+	typ, err := certTypeName(cert.CertType)
+	if err != nil {
+		return err
+	}
+
+	cols := []string{"serial", "principal", "cert_type", "fingerprint", "key_id", "valid_after", "valid_before", "issued_at", "requester_identity", "revoked"}
+	placeholders := make([]string, len(cols))
+	for i := range placeholders {
+		placeholders[i] = bindVar(s.dialect, i+1)
+	}
+	stmt := fmt.Sprintf("INSERT INTO issued_certs (%s) VALUES (%s)", strings.Join(cols, ", "), strings.Join(placeholders, ", "))
+
+	_, err = s.DB.Exec(stmt,
+		cert.Serial, cert.Principal, typ, cert.Fingerprint, cert.KeyID,
+		cert.ValidAfter, cert.ValidBefore, cert.IssuedAt, cert.RequesterIdentity, cert.Revoked)
+	if err != nil {
+		return fmt.Errorf("error recording issued cert: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) QueryIssued(filter IssuedCertFilter) (ResultIterator, error) {
+	var where []string
+	var args []interface{}
+	n := 1
+
+	if filter.Serial != nil {
+		where = append(where, fmt.Sprintf("serial = %s", bindVar(s.dialect, n)))
+		args = append(args, *filter.Serial)
+		n++
+	}
+	if filter.PrincipalLike != "" {
+		where = append(where, fmt.Sprintf("principal LIKE %s", bindVar(s.dialect, n)))
+		args = append(args, "%"+filter.PrincipalLike+"%")
+		n++
+	}
+	if filter.CertType != 0 {
+		typ, err := certTypeName(filter.CertType)
+		if err != nil {
+			return &issuedCertIterator{}, err
+		}
+		where = append(where, fmt.Sprintf("cert_type = %s", bindVar(s.dialect, n)))
+		args = append(args, typ)
+		n++
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, fmt.Sprintf("issued_at >= %s", bindVar(s.dialect, n)))
+		args = append(args, filter.Since)
+		n++
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, fmt.Sprintf("issued_at < %s", bindVar(s.dialect, n)))
+		args = append(args, filter.Until)
+		n++
+	}
+	if filter.Revoked != nil {
+		where = append(where, fmt.Sprintf("revoked = %s", bindVar(s.dialect, n)))
+		args = append(args, *filter.Revoked)
+		n++
+	}
+
+	stmt := "SELECT serial, principal, cert_type, fingerprint, key_id, valid_after, valid_before, issued_at, requester_identity, revoked FROM issued_certs"
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY issued_at DESC"
+
+	rows, err := s.DB.Query(stmt, args...)
+	if err != nil {
+		return &issuedCertIterator{}, err
+	}
+	return &issuedCertIterator{rows: rows}, nil
+}
+
+// Migrate runs any pending migrations embedded for this dialect.
+func (s *sqlStorage) Migrate() error {
+	return runMigrations(s.DB, s.dialect)
+}
+
+// certTypeName converts certType into the string stored in the cert_type
+// column, valid across all dialects.
+func certTypeName(certType uint32) (string, error) {
+	switch certType {
+	case ssh.HostCert:
+		return certHostCert, nil
+	case ssh.UserCert:
+		return certUserCert, nil
+	default:
+		return "", fmt.Errorf("storage: unknown ssh cert type: %d", certType)
+	}
+}
+
+// certTypeFromName is the inverse of certTypeName.
+func certTypeFromName(name string) (uint32, error) {
+	switch name {
+	case certHostCert:
+		return ssh.HostCert, nil
+	case certUserCert:
+		return ssh.UserCert, nil
+	default:
+		return 0, fmt.Errorf("storage: unknown cert type name: %q", name)
+	}
+}
+
+// issuedCertIterator is a ResultIterator over issued_certs rows. Get()
+// returns the serial as a string key and the row JSON-encoded as
+// IssuedCertRecord in the value, since the rows have more structure than
+// ResultIterator's (key, value) shape assumes.
+type issuedCertIterator struct {
+	rows *sql.Rows
+
+	serial string
+	record string
+	err    error
+}
+
+func (it *issuedCertIterator) Next() bool {
+	if it.rows == nil || !it.rows.Next() {
+		return false
+	}
+
+	var rec IssuedCertRecord
+	var certType string
+	if it.err = it.rows.Scan(
+		&rec.Serial, &rec.Principal, &certType, &rec.Fingerprint, &rec.KeyID,
+		&rec.ValidAfter, &rec.ValidBefore, &rec.IssuedAt, &rec.RequesterIdentity, &rec.Revoked,
+	); it.err != nil {
+		return false
+	}
+
+	rec.CertType, it.err = certTypeFromName(certType)
+	if it.err != nil {
+		return false
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.serial = fmt.Sprintf("%d", rec.Serial)
+	it.record = string(data)
+	return true
+}
+
+func (it *issuedCertIterator) Get() (string, string) {
+	return it.serial, it.record
+}
+
+func (it *issuedCertIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	if it.rows == nil {
+		return nil
+	}
+	return it.rows.Err()
+}
+
+func (it *issuedCertIterator) Close() error {
+	if it.rows == nil {
+		return nil
+	}
+	return it.rows.Close()
+}
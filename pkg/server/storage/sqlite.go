@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/square/sharkey/pkg/server/config"
+)
+
+// SqliteStorage implements the storage interface, using a local SQLite
+// file for storage. It's intended for single-node deployments and local
+// testing where running MySQL isn't worth the operational overhead.
+type SqliteStorage struct {
+	*sqlStorage
+}
+
+var _ Storage = &SqliteStorage{}
+
+func NewSqlite(cfg config.Database) (*SqliteStorage, error) {
+	db, err := sql.Open("sqlite3", cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &SqliteStorage{&sqlStorage{DB: db, dialect: dialectSQLite}}, nil
+}
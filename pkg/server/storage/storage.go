@@ -0,0 +1,154 @@
+/*-
+ * Copyright 2016 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Storage is the interface all backing stores must implement in order to
+// be used by the sharkey server.
+type Storage interface {
+	// RecordIssuance records that a certificate was issued for principal,
+	// returning the serial number assigned to the new certificate.
+	RecordIssuance(certType uint32, principal string, pubkey ssh.PublicKey) (uint64, error)
+
+	// QueryHostkeys returns all known host public keys, for building the
+	// known_hosts file served to clients.
+	QueryHostkeys() (ResultIterator, error)
+
+	// RecordRevocation records that the certificate identified by serial
+	// (or, if serial is unknown, by principal and cert type) has been
+	// revoked. It is returned by QueryRevoked until expiresAt; the KRL
+	// built from that only includes revocations that have a serial.
+	RecordRevocation(serial uint64, principal string, certType uint32, reason string, expiresAt time.Time) error
+
+	// QueryRevoked returns all non-expired revocations for the given cert
+	// type, for building a KRL.
+	QueryRevoked(certType uint32) (ResultIterator, error)
+
+	// RecordGitHubMapping stores the mapping from SSO identity to GitHub
+	// username, discarding any entries not present in mapping.
+	RecordGitHubMapping(mapping map[string]string) error
+
+	// QueryGitHubMapping looks up the GitHub username for a given SSO
+	// identity.
+	QueryGitHubMapping(ssoIdentity string) (string, error)
+
+	// RecordIssuedCert writes an audit row for a certificate that was just
+	// signed, so it shows up in QueryIssued.
+	RecordIssuedCert(cert IssuedCertRecord) error
+
+	// QueryIssued returns the issued_certs rows matching filter, newest
+	// first, for the /certs admin endpoints.
+	QueryIssued(filter IssuedCertFilter) (ResultIterator, error)
+
+	// Migrate runs any pending migrations against the backing store,
+	// using the migrations embedded for the backend's SQL dialect.
+	Migrate() error
+
+	// Close releases any resources held by the backing store.
+	Close() error
+}
+
+// IssuedCertRecord is an audit record for a single signed certificate.
+type IssuedCertRecord struct {
+	Serial            uint64    `json:"serial"`
+	Principal         string    `json:"principal"`
+	CertType          uint32    `json:"cert_type"`
+	Fingerprint       string    `json:"fingerprint"`
+	KeyID             string    `json:"key_id"`
+	ValidAfter        time.Time `json:"valid_after"`
+	ValidBefore       time.Time `json:"valid_before"`
+	IssuedAt          time.Time `json:"issued_at"`
+	RequesterIdentity string    `json:"requester_identity"`
+	Revoked           bool      `json:"revoked"`
+}
+
+// IssuedCertFilter narrows the rows returned by QueryIssued. The zero value
+// matches every row.
+type IssuedCertFilter struct {
+	// Serial restricts to a single serial, for GET /certs/{serial}. Nil
+	// matches every serial.
+	Serial *uint64
+
+	// PrincipalLike matches principals containing this substring. Empty
+	// matches every principal.
+	PrincipalLike string
+
+	// CertType restricts to a single cert type. Zero matches both.
+	CertType uint32
+
+	// Since and Until restrict to certs issued in [Since, Until). Zero
+	// values leave that side of the window open.
+	Since time.Time
+	Until time.Time
+
+	// Revoked restricts to revoked or non-revoked certs. Nil matches both.
+	Revoked *bool
+}
+
+// ResultIterator iterates over a set of (key, value) pairs returned from a
+// storage query, e.g. hostname/pubkey pairs for QueryHostkeys.
+type ResultIterator interface {
+	Next() bool
+	Get() (string, string)
+	Err() error
+	Close() error
+}
+
+// SqlResultIterator is a ResultIterator backed by *sql.Rows, where each row
+// has exactly two columns.
+type SqlResultIterator struct {
+	Rows *sql.Rows
+
+	key   string
+	value string
+	err   error
+}
+
+func (s *SqlResultIterator) Next() bool {
+	if s.Rows == nil || !s.Rows.Next() {
+		return false
+	}
+	s.err = s.Rows.Scan(&s.key, &s.value)
+	return s.err == nil
+}
+
+func (s *SqlResultIterator) Get() (string, string) {
+	return s.key, s.value
+}
+
+func (s *SqlResultIterator) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.Rows == nil {
+		return nil
+	}
+	return s.Rows.Err()
+}
+
+func (s *SqlResultIterator) Close() error {
+	if s.Rows == nil {
+		return nil
+	}
+	return s.Rows.Close()
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/square/sharkey/pkg/server/config"
+)
+
+// NewStorage constructs the Storage implementation selected by
+// cfg.Type ("mysql", "sqlite", "postgres" or "memory").
+func NewStorage(cfg config.Database) (Storage, error) {
+	switch cfg.Type {
+	case "", "mysql":
+		return NewMysql(cfg)
+	case "sqlite":
+		return NewSqlite(cfg)
+	case "postgres":
+		return NewPostgres(cfg)
+	case "memory":
+		return NewMemory(cfg)
+	default:
+		return nil, fmt.Errorf("storage: unknown database type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,137 @@
+/*-
+ * Copyright 2016 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/square/sharkey/pkg/server/storage"
+)
+
+// ListCerts handles GET /certs, returning issuance audit records matching
+// the optional principal, cert_type, since, until and revoked query
+// parameters.
+func (c *context) ListCerts(w http.ResponseWriter, r *http.Request) {
+	if !clientAuthenticated(r) {
+		http.Error(w, "no client certificate provided", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.IssuedCertFilter{
+		PrincipalLike: query.Get("principal"),
+	}
+
+	if certType := query.Get("cert_type"); certType != "" {
+		typ, err := parseCertType(certType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.CertType = typ
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			http.Error(w, "invalid until parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Until = t
+	}
+	if revoked := query.Get("revoked"); revoked != "" {
+		b, err := strconv.ParseBool(revoked)
+		if err != nil {
+			http.Error(w, "invalid revoked parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Revoked = &b
+	}
+
+	c.writeCerts(w, filter)
+}
+
+// parseCertType converts the cert_type query parameter ("host" or "user")
+// into the uint32 cert type the storage layer expects.
+func parseCertType(certType string) (uint32, error) {
+	switch certType {
+	case "host":
+		return ssh.HostCert, nil
+	case "user":
+		return ssh.UserCert, nil
+	default:
+		return 0, fmt.Errorf("invalid cert_type parameter: %q", certType)
+	}
+}
+
+// ShowCert handles GET /certs/{serial}, returning the audit records for a
+// single serial as a JSON array, which is empty if the serial is unknown.
+func (c *context) ShowCert(w http.ResponseWriter, r *http.Request) {
+	if !clientAuthenticated(r) {
+		http.Error(w, "no client certificate provided", http.StatusUnauthorized)
+		return
+	}
+
+	serial, err := strconv.ParseUint(mux.Vars(r)["serial"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid serial", http.StatusBadRequest)
+		return
+	}
+
+	c.writeCerts(w, storage.IssuedCertFilter{Serial: &serial})
+}
+
+func (c *context) writeCerts(w http.ResponseWriter, filter storage.IssuedCertFilter) {
+	iter, err := c.storage.QueryIssued(filter)
+	if err != nil {
+		log.Printf("error querying issued certs: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer iter.Close()
+
+	certs := []json.RawMessage{}
+	for iter.Next() {
+		_, record := iter.Get()
+		certs = append(certs, json.RawMessage(record))
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("error querying issued certs: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(certs); err != nil {
+		log.Printf("error encoding issued certs: %s", err.Error())
+	}
+}
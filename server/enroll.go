@@ -18,7 +18,6 @@ package main
 
 import (
 	"crypto/rand"
-	"database/sql"
 	"encoding/base64"
 	"io/ioutil"
 	"log"
@@ -27,8 +26,9 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	sqlite3 "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/ssh"
+
+	"github.com/square/sharkey/pkg/server/storage"
 )
 
 const (
@@ -63,35 +63,35 @@ func (c *context) EnrollHost(hostname string, r *http.Request) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	encodedPubkey := strings.TrimLeft(string(data), "\n")
 	pubkey, _, _, _, err := ssh.ParseAuthorizedKey(data)
 	if err != nil {
 		return "", err
 	}
 
-	// Update table with host
-	var result sql.Result
-	if _, ok := c.db.Driver().(*sqlite3.SQLiteDriver); ok {
-		// SQLite supports "insert or replace" for insert-or-update
-		result, err = c.db.Exec(
-			"INSERT OR REPLACE INTO hostkeys (hostname, pubkey) VALUES (?, ?)",
-			encodedPubkey, hostname)
-	} else {
-		// MySQL supports "on duplicate key update" for insert-or-update
-		result, err = c.db.Exec(
-			"INSERT INTO hostkeys (hostname, pubkey) VALUES (?, ?) ON DUPLICATE KEY UPDATE pubkey = ?",
-			hostname, encodedPubkey, encodedPubkey)
-	}
+	// Record the issuance and allocate this enrollment a serial. This
+	// also upserts the hostkeys row, so a re-enrollment of an already
+	// known host gets a fresh serial rather than reusing the old one.
+	serial, err := c.storage.RecordIssuance(sshHostCertificateType, hostname, pubkey)
 	if err != nil {
 		return "", err
 	}
 
-	id, err := result.LastInsertId()
+	signedCert, err := c.signHost(hostname, serial, pubkey)
 	if err != nil {
 		return "", err
 	}
 
-	signedCert, err := c.signHost(hostname, uint64(id), pubkey)
+	err = c.storage.RecordIssuedCert(storage.IssuedCertRecord{
+		Serial:            serial,
+		Principal:         hostname,
+		CertType:          sshHostCertificateType,
+		Fingerprint:       ssh.FingerprintSHA256(pubkey),
+		KeyID:             signedCert.KeyId,
+		ValidAfter:        time.Unix(int64(signedCert.ValidAfter), 0),
+		ValidBefore:       time.Unix(int64(signedCert.ValidBefore), 0),
+		IssuedAt:          time.Now(),
+		RequesterIdentity: clientIdentity(r),
+	})
 	if err != nil {
 		return "", err
 	}
@@ -114,6 +114,16 @@ func clientHostnameMatches(hostname string, r *http.Request) bool {
 	return cert.VerifyHostname(hostname) == nil
 }
 
+// clientIdentity returns the common name of the client certificate that
+// authenticated r, for recording who requested a given issuance.
+func clientIdentity(r *http.Request) string {
+	conn := r.TLS
+	if len(conn.VerifiedChains) == 0 {
+		return ""
+	}
+	return conn.VerifiedChains[0][0].Subject.CommonName
+}
+
 func (c *context) signHost(hostname string, serial uint64, pubkey ssh.PublicKey) (*ssh.Certificate, error) {
 	nonce := make([]byte, 32)
 	_, err := rand.Read(nonce)
@@ -0,0 +1,31 @@
+/*-
+ * Copyright 2016 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "github.com/gorilla/mux"
+
+// NewRouter builds the mux.Router that serves the sharkey HTTP API,
+// wiring every handler in this package to its route.
+func NewRouter(c *context) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/enroll/{hostname}", c.Enroll).Methods("POST")
+	r.HandleFunc("/krl/host", c.KRLHost).Methods("GET")
+	r.HandleFunc("/krl/user", c.KRLUser).Methods("GET")
+	r.HandleFunc("/certs", c.ListCerts).Methods("GET")
+	r.HandleFunc("/certs/{serial}", c.ShowCert).Methods("GET")
+	return r
+}